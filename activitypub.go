@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// actorClient is used for every outbound request to a remote actor (key
+// fetches, inbox lookups, inbox deliveries): these target attacker-supplied
+// URLs, so a fixed timeout keeps a slow or non-responding remote from
+// blocking a handler goroutine indefinitely.
+var actorClient = &http.Client{Timeout: 10 * time.Second}
+
+// blogUsername is the single account this server federates as; the blog
+// only ever runs one actor, so there's no need for per-user routing.
+const blogUsername = "blog"
+
+// Actor is a minimal ActivityPub actor object advertising a Person with an
+// RSA public key, enough for Mastodon and friends to follow and verify us.
+type Actor struct {
+	Context           []string  `json:"@context"`
+	Id                string    `json:"id"`
+	Type              string    `json:"type"`
+	PreferredUsername string    `json:"preferredUsername"`
+	Inbox             string    `json:"inbox"`
+	PublicKey         PublicKey `json:"publicKey"`
+}
+
+type PublicKey struct {
+	Id           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+// Activity covers the subset of the ActivityStreams vocabulary this blog
+// needs to send and receive: Follow, Undo, Create, Accept and Note/Article.
+type Activity struct {
+	Context string          `json:"@context"`
+	Id      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Actor   string          `json:"actor,omitempty"`
+	Object  json.RawMessage `json:"object,omitempty"`
+	To      []string        `json:"to,omitempty"`
+}
+
+type Note struct {
+	Id        string `json:"id"`
+	Type      string `json:"type"`
+	AttrTo    string `json:"attributedTo"`
+	InReplyTo string `json:"inReplyTo,omitempty"`
+	Content   string `json:"content"`
+	Published string `json:"published"`
+	To        []string `json:"to,omitempty"`
+}
+
+// jrd and xrd back webfinger/host-meta; both just point remote servers at
+// the actor document for content negotiation.
+type jrdLink struct {
+	Rel      string `json:"rel"`
+	Type     string `json:"type,omitempty"`
+	Href     string `json:"href,omitempty"`
+	Template string `json:"template,omitempty"`
+}
+
+type jrd struct {
+	Subject string    `json:"subject"`
+	Links   []jrdLink `json:"links"`
+}
+
+type xrdLink struct {
+	XMLName  xml.Name `xml:"Link"`
+	Rel      string   `xml:"rel,attr"`
+	Type     string   `xml:"type,attr,omitempty"`
+	Template string   `xml:"template,attr,omitempty"`
+}
+
+type xrd struct {
+	XMLName xml.Name  `xml:"XRD"`
+	Xmlns   string    `xml:"xmlns,attr"`
+	Links   []xrdLink `xml:"Link"`
+}
+
+func actorIRI(siteURL string) string {
+	return fmt.Sprintf("%s/actor", siteURL)
+}
+
+func handleWebfinger(siteURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resource := r.URL.Query().Get("resource")
+		expected := fmt.Sprintf("acct:%s@%s", blogUsername, strings.TrimPrefix(strings.TrimPrefix(siteURL, "https://"), "http://"))
+		if resource != expected {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/jrd+json; charset=utf-8")
+		check(json.NewEncoder(w).Encode(jrd{
+			Subject: resource,
+			Links: []jrdLink{
+				{Rel: "self", Type: "application/activity+json", Href: actorIRI(siteURL)},
+			},
+		}))
+	}
+}
+
+func handleHostMeta(siteURL string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xrd+xml; charset=utf-8")
+		check(xml.NewEncoder(w).Encode(xrd{
+			Xmlns: "http://docs.oasis-open.org/ns/xri/xrd-1.0",
+			Links: []xrdLink{
+				{Rel: "lrdd", Template: fmt.Sprintf("%s/.well-known/webfinger?resource={uri}", siteURL)},
+			},
+		}))
+	}
+}
+
+func handleActor(siteURL string, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := store.PrivateKey()
+		check(err)
+
+		pubKeyBytes, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+		check(err)
+		pubKeyPem := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKeyBytes}))
+
+		iri := actorIRI(siteURL)
+		w.Header().Set("Content-Type", "application/activity+json; charset=utf-8")
+		check(json.NewEncoder(w).Encode(Actor{
+			Context:           []string{"https://www.w3.org/ns/activitystreams"},
+			Id:                iri,
+			Type:              "Person",
+			PreferredUsername: blogUsername,
+			Inbox:             fmt.Sprintf("%s/inbox", siteURL),
+			PublicKey: PublicKey{
+				Id:           fmt.Sprintf("%s#main-key", iri),
+				Owner:        iri,
+				PublicKeyPem: pubKeyPem,
+			},
+		}))
+	}
+}
+
+// fetchActorKey retrieves a remote actor document and parses its public key
+// so an incoming HTTP signature can be verified against it.
+func fetchActorKey(actorIRI string) (*rsa.PublicKey, error) {
+	req, err := http.NewRequest("GET", actorIRI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/activity+json")
+
+	resp, err := actorClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var actor Actor
+	if err := json.NewDecoder(resp.Body).Decode(&actor); err != nil {
+		return nil, fmt.Errorf("activitypub: actor %s returned an unparsable body: %w", actorIRI, err)
+	}
+
+	block, _ := pem.Decode([]byte(actor.PublicKey.PublicKeyPem))
+	if block == nil {
+		return nil, fmt.Errorf("activitypub: actor %s has no usable public key", actorIRI)
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaPub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("activitypub: actor %s public key is not RSA", actorIRI)
+	}
+	return rsaPub, nil
+}
+
+// verifyHTTPSignature implements enough of draft-cavage-http-signatures to
+// interop with Mastodon: it rebuilds the signing string from the headers
+// named in the Signature header and checks it against the sender's key.
+func verifyHTTPSignature(r *http.Request) error {
+	sigHeader := r.Header.Get("Signature")
+	if sigHeader == "" {
+		return fmt.Errorf("activitypub: missing Signature header")
+	}
+
+	params := make(map[string]string)
+	for _, part := range strings.Split(sigHeader, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+
+	keyId, signatureB64 := params["keyId"], params["signature"]
+	headerNames := strings.Split(params["headers"], " ")
+	if keyId == "" || signatureB64 == "" || len(headerNames) == 0 {
+		return fmt.Errorf("activitypub: malformed Signature header")
+	}
+
+	var lines []string
+	for _, name := range headerNames {
+		if name == "(request-target)" {
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s", strings.ToLower(r.Method), r.URL.Path))
+		} else if name == "host" {
+			// net/http moves Host out of r.Header into r.Host
+			lines = append(lines, fmt.Sprintf("host: %s", r.Host))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", name, r.Header.Get(name)))
+		}
+	}
+	signingString := strings.Join(lines, "\n")
+
+	// keyId is the actor's main-key fragment; strip it back to the actor IRI.
+	actorURL := strings.SplitN(keyId, "#", 2)[0]
+	pubKey, err := fetchActorKey(actorURL)
+	if err != nil {
+		return err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(signatureB64)
+	if err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256([]byte(signingString))
+	return rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, digest[:], signature)
+}
+
+// signAndPost signs body with the blog's private key per HTTP Signatures
+// and delivers it to a remote inbox, the same path used both for Accept
+// replies and for fanning out new posts to followers.
+func signAndPost(store *Store, siteURL, inboxURL string, body []byte) error {
+	key, err := store.PrivateKey()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", inboxURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/activity+json")
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+
+	signingString := fmt.Sprintf("(request-target): post %s\nhost: %s\ndate: %s",
+		req.URL.Path, req.URL.Host, req.Header.Get("Date"))
+
+	digest := sha256.Sum256([]byte(signingString))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return err
+	}
+
+	iri := actorIRI(siteURL)
+	req.Header.Set("Signature", fmt.Sprintf(
+		`keyId="%s#main-key",algorithm="rsa-sha256",headers="(request-target) host date",signature="%s"`,
+		iri, base64.StdEncoding.EncodeToString(signature),
+	))
+
+	resp, err := actorClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return nil
+}
+
+// handleInbox accepts Follow, Undo Follow, and Create Note activities from
+// remote actors, verifying the sender's HTTP signature before acting. The
+// request body and anything fetched from the sender's actor IRI are
+// untrusted, so parse failures are reported to the client instead of
+// panicking the handler goroutine.
+func handleInbox(siteURL string, store *Store, posts *[]Post, postsMutex *sync.RWMutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := verifyHTTPSignature(r); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var activity Activity
+		if err := json.Unmarshal(body, &activity); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch activity.Type {
+		case "Follow":
+			inbox, err := inboxOf(activity.Actor)
+			if err != nil {
+				log.Printf("activitypub: couldn't resolve inbox for follower %s: %s", activity.Actor, err)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+
+			if err := store.AddFollower(activity.Actor, inbox); err != nil {
+				log.Printf("activitypub: couldn't record follower %s: %s", activity.Actor, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			accept := Activity{
+				Context: "https://www.w3.org/ns/activitystreams",
+				Id:      fmt.Sprintf("%s/activities/%s", siteURL, randomID()),
+				Type:    "Accept",
+				Actor:   actorIRI(siteURL),
+				Object:  body,
+			}
+			acceptBody, err := json.Marshal(accept)
+			check(err)
+			if err := signAndPost(store, siteURL, inbox, acceptBody); err != nil {
+				log.Printf("activitypub: couldn't deliver Accept to %s: %s", inbox, err)
+			}
+
+		case "Undo":
+			var inner Activity
+			if err := json.Unmarshal(activity.Object, &inner); err == nil && inner.Type == "Follow" {
+				check(store.RemoveFollower(inner.Actor))
+			}
+
+		case "Create":
+			var note Note
+			if err := json.Unmarshal(activity.Object, &note); err == nil && note.Type == "Note" && note.InReplyTo != "" {
+				postsMutex.RLock()
+				defer postsMutex.RUnlock()
+
+				for i := range *posts {
+					postURL := fmt.Sprintf("%s/posts/%s", siteURL, (*posts)[i].Id)
+					if note.InReplyTo == postURL {
+						check(store.AddComment((*posts)[i].Id, Comment{
+							Name:    note.AttrTo,
+							Content: note.Content,
+						}, note.Id))
+						break
+					}
+				}
+			}
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// inboxOf fetches a remote actor just to read its inbox URL; Follow
+// activities only carry the actor IRI, not the inbox itself.
+func inboxOf(actorURL string) (string, error) {
+	resp, err := actorClient.Get(actorURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var actor Actor
+	if err := json.NewDecoder(resp.Body).Decode(&actor); err != nil {
+		return "", fmt.Errorf("activitypub: actor %s returned an unparsable body: %w", actorURL, err)
+	}
+	return actor.Inbox, nil
+}
+
+// deliverPost fans a newly-loaded post out to every follower's inbox as a
+// Create Note activity.
+func deliverPost(store *Store, siteURL string, post *Post) {
+	followers, err := store.Followers()
+	check(err)
+
+	postURL := fmt.Sprintf("%s/posts/%s", siteURL, post.Id)
+	note := Note{
+		Id:        fmt.Sprintf("%s#note", postURL),
+		Type:      "Note",
+		AttrTo:    actorIRI(siteURL),
+		Content:   string(post.Render()),
+		Published: post.Date,
+		To:        []string{"https://www.w3.org/ns/activitystreams#Public"},
+	}
+
+	create := Activity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      fmt.Sprintf("%s/activities/%s", siteURL, randomID()),
+		Type:    "Create",
+		Actor:   actorIRI(siteURL),
+		To:      note.To,
+	}
+	noteBytes, err := json.Marshal(note)
+	check(err)
+	create.Object = noteBytes
+
+	createBytes, err := json.Marshal(create)
+	check(err)
+
+	for _, follower := range followers {
+		go func(inbox string) {
+			if err := signAndPost(store, siteURL, inbox, createBytes); err != nil {
+				log.Printf("activitypub: couldn't deliver Create to %s: %s", inbox, err)
+			}
+		}(follower.Inbox)
+	}
+}
+
+func randomID() string {
+	return fmt.Sprintf("%d", time.Now().UnixNano())
+}
+
+// handlePostActivity serves /posts/{id} as an ActivityPub Article when the
+// client asked for application/activity+json.
+func handlePostActivity(siteURL string, post *Post) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postURL := fmt.Sprintf("%s/posts/%s", siteURL, post.Id)
+		w.Header().Set("Content-Type", "application/activity+json; charset=utf-8")
+		check(json.NewEncoder(w).Encode(Note{
+			Id:        fmt.Sprintf("%s#note", postURL),
+			Type:      "Article",
+			AttrTo:    actorIRI(siteURL),
+			Content:   string(post.Render()),
+			Published: post.Date,
+			To:        []string{"https://www.w3.org/ns/activitystreams#Public"},
+		}))
+	}
+}
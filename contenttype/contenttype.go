@@ -0,0 +1,58 @@
+// Package contenttype centralizes response content-type handling: setting
+// the right Content-Type header (with a charset suffix) and streaming the
+// body through a shared minifier, so every handler doesn't reimplement it.
+package contenttype
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/json"
+	"github.com/tdewolff/minify/v2/xml"
+)
+
+var m = minify.New()
+
+func init() {
+	m.AddFunc("text/html", html.Minify)
+	m.AddFunc("text/css", css.Minify)
+	m.AddFunc("application/javascript", js.Minify)
+	m.AddFunc("application/json", json.Minify)
+	m.AddFunc("application/activity+json", json.Minify)
+	m.AddFunc("application/xml", xml.Minify)
+	m.AddFunc("application/atom+xml", xml.Minify)
+	m.AddFunc("application/xrd+xml", xml.Minify)
+}
+
+// Write sets the Content-Type header for mediaType (with a charset=utf-8
+// suffix) and streams write's output through the minifier registered for
+// that type.
+func Write(w http.ResponseWriter, mediaType string, write func(io.Writer) error) error {
+	w.Header().Set("Content-Type", mediaType+"; charset=utf-8")
+
+	mw := m.Writer(mediaType, w)
+	if err := write(mw); err != nil {
+		mw.Close()
+		return err
+	}
+	return mw.Close()
+}
+
+// WriteHTML writes a text/html response.
+func WriteHTML(w http.ResponseWriter, write func(io.Writer) error) error {
+	return Write(w, "text/html", write)
+}
+
+// WriteJSON writes an application/json response.
+func WriteJSON(w http.ResponseWriter, write func(io.Writer) error) error {
+	return Write(w, "application/json", write)
+}
+
+// WriteXML writes an application/xml response.
+func WriteXML(w http.ResponseWriter, write func(io.Writer) error) error {
+	return Write(w, "application/xml", write)
+}
@@ -0,0 +1,186 @@
+//go:build !prod
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// devScript is injected into every HTML response in dev mode; it opens an
+// SSE connection to /_dev/reload and reloads the page on the next event.
+const devScript = `<script>new EventSource("/_dev/reload").onmessage = () => location.reload()</script>`
+
+// devServer watches posts/, templates/, and static/ for changes and pushes
+// reload events to connected browsers over SSE. Only built into non-prod
+// binaries (see dev_prod.go) so fsnotify never ships in a release build.
+type devServer struct {
+	mu      sync.Mutex
+	clients map[chan struct{}]bool
+}
+
+// startDevMode starts the filesystem watcher when enabled and returns a
+// devServer to wire into the mux; returns nil when dev mode is off.
+func startDevMode(enabled bool, posts *[]Post, postsMutex *sync.RWMutex, store *Store, siteURL string) *devServer {
+	if !enabled {
+		return nil
+	}
+
+	d := &devServer{clients: make(map[chan struct{}]bool)}
+
+	watcher, err := fsnotify.NewWatcher()
+	check(err)
+
+	for _, dir := range []string{"posts", "templates", "static"} {
+		check(watcher.Add(dir))
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.Printf("dev: %s changed, reloading", event.Name)
+
+				if strings.HasPrefix(event.Name, "posts") {
+					reloadPosts(posts, postsMutex, store, siteURL)
+				}
+				clearRenderCache()
+
+				d.broadcast()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("dev: watcher error: %s", err)
+			}
+		}
+	}()
+
+	return d
+}
+
+// reloadPosts reparses posts/ and swaps it into *posts. getPostList panics
+// on a malformed frontmatter block, which is expected mid-edit in dev mode
+// (e.g. a save while the YAML header is half-written), so this runs inside
+// its own goroutine, not a request handler — recover and log instead of
+// letting it take down the whole dev server.
+func reloadPosts(posts *[]Post, postsMutex *sync.RWMutex, store *Store, siteURL string) {
+	postsMutex.Lock()
+	defer postsMutex.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("dev: couldn't reload posts, keeping the previous list: %s", r)
+		}
+	}()
+
+	previousIds := make(map[string]bool, len(*posts))
+	for _, post := range *posts {
+		previousIds[post.Id] = true
+	}
+
+	*posts = getPostList()
+
+	// federate any post that wasn't in the list before, the same as a
+	// micropub create would
+	for i := range *posts {
+		if !previousIds[(*posts)[i].Id] {
+			deliverPost(store, siteURL, &(*posts)[i])
+		}
+	}
+}
+
+func (d *devServer) broadcast() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for client := range d.clients {
+		client <- struct{}{}
+	}
+}
+
+// handleReload is the SSE endpoint the injected dev script connects to.
+func (d *devServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	client := make(chan struct{})
+	d.mu.Lock()
+	d.clients[client] = true
+	d.mu.Unlock()
+
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, client)
+		d.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-client:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// middleware injects devScript into HTML responses just before </body>, or
+// at the end of the body when the minifier has dropped the optional </body>
+// tag. The /_dev/reload stream itself is passed through unbuffered.
+func (d *devServer) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/_dev/reload" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		buf := &bytes.Buffer{}
+		rec := &devResponseRecorder{ResponseWriter: w, body: buf}
+		next.ServeHTTP(rec, r)
+
+		body := buf.Bytes()
+		if strings.Contains(rec.Header().Get("Content-Type"), "text/html") {
+			if bytes.Contains(body, []byte("</body>")) {
+				body = bytes.Replace(body, []byte("</body>"), []byte(devScript+"</body>"), 1)
+			} else {
+				body = append(body, devScript...)
+			}
+		}
+		w.Write(body)
+	})
+}
+
+// devResponseRecorder buffers the body so middleware can inject the reload
+// script before it's written to the real ResponseWriter.
+type devResponseRecorder struct {
+	http.ResponseWriter
+	body       *bytes.Buffer
+	statusCode int
+}
+
+func (r *devResponseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
+
+func (r *devResponseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
@@ -0,0 +1,24 @@
+//go:build prod
+
+package main
+
+import (
+	"net/http"
+	"sync"
+)
+
+// devServer is a no-op stub for production builds (built with -tags prod)
+// so fsnotify and the live-reload watcher never ship in a release binary.
+type devServer struct{}
+
+func startDevMode(enabled bool, posts *[]Post, postsMutex *sync.RWMutex, store *Store, siteURL string) *devServer {
+	return nil
+}
+
+func (d *devServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	http.NotFound(w, r)
+}
+
+func (d *devServer) middleware(next http.Handler) http.Handler {
+	return next
+}
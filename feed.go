@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Atom feed structs, kept to just the elements readers actually care about.
+type atomFeed struct {
+	XMLName xml.Name    `xml:"feed"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	Id      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	Id        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Link      atomLink    `xml:"link"`
+	Content   atomContent `xml:"content"`
+}
+
+type atomContent struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",cdata"`
+}
+
+// handleAtomFeed renders /feed.atom from the same []Post list used to
+// render the index page, reusing Render() so markdown is parsed once and
+// shared with the HTML pages via the render cache.
+func handleAtomFeed(siteURL string, posts *[]Post, postsMutex *sync.RWMutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		feed := atomFeed{
+			Xmlns:   "http://www.w3.org/2005/Atom",
+			Id:      siteURL + "/",
+			Title:   "Blog",
+			Link:    atomLink{Href: siteURL + "/feed.atom", Rel: "self"},
+			Author:  atomAuthor{Name: "Blog"},
+		}
+
+		// no preview token support here: this is a machine-consumed, often
+		// crawled/cached endpoint, the worst place to accept a secret
+		// bypass token for drafts
+		postsMutex.RLock()
+		visible := visiblePosts(*posts, "")
+		postsMutex.RUnlock()
+
+		var latest string
+		for i := range visible {
+			post := &visible[i]
+			entryURL := fmt.Sprintf("%s/posts/%s", siteURL, post.Id)
+
+			feed.Entries = append(feed.Entries, atomEntry{
+				Id:        tagURI(siteURL, post.Date, post.Id),
+				Title:     post.Title,
+				Updated:   post.Date,
+				Published: post.Date,
+				Link:      atomLink{Href: entryURL},
+				Content:   atomContent{Type: "html", Body: string(post.Render())},
+			})
+
+			if post.Date > latest {
+				latest = post.Date
+			}
+		}
+		feed.Updated = latest
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		check(xml.NewEncoder(w).Encode(feed))
+	}
+}
+
+// sitemap structs per the sitemaps.org schema.
+type sitemapURLSet struct {
+	XMLName xml.Name    `xml:"urlset"`
+	Xmlns   string      `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// handleSitemap renders /sitemap.xml listing the index and every post.
+func handleSitemap(siteURL string, posts *[]Post, postsMutex *sync.RWMutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		urlset := sitemapURLSet{
+			Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9",
+			URLs:  []sitemapURL{{Loc: siteURL + "/"}},
+		}
+
+		// no preview token support here either, for the same reason as
+		// handleAtomFeed
+		postsMutex.RLock()
+		visible := visiblePosts(*posts, "")
+		postsMutex.RUnlock()
+		for i := range visible {
+			post := &visible[i]
+			urlset.URLs = append(urlset.URLs, sitemapURL{
+				Loc:     fmt.Sprintf("%s/posts/%s", siteURL, post.Id),
+				LastMod: post.Date,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/xml")
+		check(xml.NewEncoder(w).Encode(urlset))
+	}
+}
+
+// tagURI turns the canonical site URL into the authority component of a
+// tag: URI (RFC 4151) built from that host, the post date and the post id.
+// The host is read from siteURL on every call, so entry ids change if the
+// site URL's host changes.
+func tagURI(siteURL, date, id string) string {
+	u, err := url.Parse(siteURL)
+	check(err)
+	return fmt.Sprintf("tag:%s,%s:%s", u.Host, date, id)
+}
@@ -1,57 +1,158 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/grimsteel/go-blog/contenttype"
 )
 
 func main() {
+	devFlag := flag.Bool("dev", false, "watch posts/templates/static and live-reload the browser")
+	flag.Parse()
+	isDev := *devFlag || os.Getenv("DEV") == "1"
+
 	listen_address := os.Getenv("LISTEN_ADDRESS")
 	if len(listen_address) == 0 {
 		// set a default address
 		listen_address = "127.0.0.1:8080"
 	}
 
+	// the canonical, externally-reachable URL for this blog; used to build
+	// the ActivityPub actor IRI and inbox delivery addresses
+	siteURL := os.Getenv("SITE_URL")
+	if len(siteURL) == 0 {
+		siteURL = fmt.Sprintf("http://%s", listen_address)
+	}
+
 	// read posts
 	posts := getPostList()
+	// guards posts: writers (micropub create, dev-mode reload) take Lock,
+	// every reader takes RLock so a reload can never race a request
+	// iterating the slice
+	var postsMutex sync.RWMutex
 
-	// store comments in-memory
-	comments := make(map[string][]Comment)
+	// federation state (followers, keys, comments) now lives in sqlite so
+	// it survives restarts
+	store, err := NewStore("blog.db")
+	check(err)
+	defer store.Close()
 
   mux := http.NewServeMux()
 
+	mux.HandleFunc("/.well-known/webfinger", handleWebfinger(siteURL))
+	mux.HandleFunc("/.well-known/host-meta", handleHostMeta(siteURL))
+	mux.HandleFunc("/actor", handleActor(siteURL, store))
+	mux.HandleFunc("/inbox", handleInbox(siteURL, store, &posts, &postsMutex))
+
+	mux.HandleFunc("/feed.atom", handleAtomFeed(siteURL, &posts, &postsMutex))
+	mux.HandleFunc("/sitemap.xml", handleSitemap(siteURL, &posts, &postsMutex))
+
+	// IndieAuth-gated endpoint for micropub clients (e.g. Quill) to publish
+	// posts and comments
+	tokenEndpoint := os.Getenv("INDIEAUTH_TOKEN_ENDPOINT")
+	meOwner := os.Getenv("INDIEAUTH_ME")
+	mux.HandleFunc("/micropub", handleMicropub(tokenEndpoint, meOwner, siteURL, &posts, &postsMutex, store))
+
+	// live-reload dev mode: watches posts/templates/static and pushes
+	// reload events to the browser over SSE
+	dev := startDevMode(isDev, &posts, &postsMutex, store, siteURL)
+	mux.HandleFunc("/_dev/reload", func(w http.ResponseWriter, r *http.Request) {
+		if dev == nil {
+			http.NotFound(w, r)
+			return
+		}
+		dev.handleReload(w, r)
+	})
+
 	// serve static files
 	staticPath := "/static/"
   mux.Handle(staticPath, http.StripPrefix(staticPath, http.FileServer(http.Dir("./static"))))
 	
   mux.HandleFunc("/", func (w http.ResponseWriter, r *http.Request) {
-		renderTemplate(&posts, "index", w)
+		postsMutex.RLock()
+		visible := visiblePosts(posts, r.URL.Query().Get("preview"))
+		postsMutex.RUnlock()
+
+		renderTemplate(&visible, "index", w)
+	})
+
+	mux.HandleFunc("/tags/{tag}", func (w http.ResponseWriter, r *http.Request) {
+		tag := r.PathValue("tag")
+		preview := r.URL.Query().Get("preview")
+
+		postsMutex.RLock()
+		visible := visiblePosts(posts, preview)
+		postsMutex.RUnlock()
+
+		var tagged []Post
+		for _, post := range visible {
+			if post.HasTag(tag) {
+				tagged = append(tagged, post)
+			}
+		}
+
+		renderTemplate(&tagged, "index", w)
 	})
 
 	// wildcard recently added
 	mux.HandleFunc("/posts/{id}", func (w http.ResponseWriter, r *http.Request) {
 		postId := r.PathValue("id")
-		// initialize to nil
+		// initialize to nil; copy the matching post out from under the lock
+		// so it's safe to use after RUnlock
 		var post *Post = nil
+		postsMutex.RLock()
 		for i := range posts {
 			if posts[i].Id == postId {
-				post = &posts[i]
+				found := posts[i]
+				post = &found
 				break
 			}
 		}
+		postsMutex.RUnlock()
 
-		// not found
-		if post == nil {
+		// not found (including drafts without a matching preview token)
+		if post == nil || (post.Draft && (previewToken == "" || r.URL.Query().Get("preview") != previewToken)) {
 			w.WriteHeader(404)
 			renderTemplate(nil, "404", w)
-		} else {
+			return
+		}
+
+		// content negotiation: federated servers want the post as an
+		// ActivityPub object, API clients want metadata + rendered HTML,
+		// browsers get the rendered HTML page
+		accept := r.Header.Get("Accept")
+		switch {
+		case strings.Contains(accept, "application/activity+json"):
+			handlePostActivity(siteURL, post)(w, r)
+		case strings.Contains(accept, "application/json"):
+			check(contenttype.WriteJSON(w, func(out io.Writer) error {
+				return json.NewEncoder(out).Encode(struct {
+					Post
+					Content template.HTML `json:"content"`
+				}{Post: *post, Content: post.Render()})
+			}))
+		default:
+			postComments, err := store.Comments(postId)
+			check(err)
+
 			renderTemplate(struct {
 				Post *Post
 				Comments []Comment
 			} {
 				Post: post,
-				Comments: comments[postId],
+				Comments: postComments,
 			}, "post", w)
 		}
 	})
@@ -62,21 +163,44 @@ func main() {
 		message := r.FormValue("message")
 
 		// add to comments
-		comments[postId] = append(comments[postId], Comment {
-			name,
-			message,
-		})
+		check(store.AddComment(postId, Comment{name, message}, ""))
 
 		// redirect to post
 		http.Redirect(w, r, fmt.Sprintf("/posts/%s", postId), http.StatusSeeOther)
 	})
 
+	var handler http.Handler = mux
+	if dev != nil {
+		handler = dev.middleware(mux)
+	}
+
   server := &http.Server{
     Addr:     listen_address,
-    Handler:  mux,
+    Handler:  handler,
   }
 
+	// shut down cleanly on SIGINT/SIGTERM so in-flight requests finish
+	// instead of being killed mid-response
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		fmt.Println("Shutting down...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		check(server.Shutdown(shutdownCtx))
+	}()
+
 	// start the server
 	fmt.Printf("Listening on %s\n", listen_address)
-  check(server.ListenAndServe())
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		check(err)
+	}
+	// ListenAndServe returns as soon as Shutdown starts; wait for it to
+	// finish draining in-flight requests
+	<-shutdownDone
 }
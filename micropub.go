@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// micropubEntry is the subset of the micropub "h=entry" vocabulary this
+// blog accepts from clients like Quill.
+type micropubEntry struct {
+	Content    string   `json:"content"`
+	Name       string   `json:"name"`
+	Category   []string `json:"category"`
+	Published  string   `json:"published"`
+	LikeOf     string   `json:"like-of"`
+	InReplyTo  string   `json:"in-reply-to"`
+}
+
+var slugInvalidChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+func slugify(title string) string {
+	slug := strings.Trim(slugInvalidChars.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if slug == "" {
+		return fmt.Sprintf("post-%d", time.Now().Unix())
+	}
+	return slug
+}
+
+// verifyIndieAuthToken posts the bearer token to the configured token
+// endpoint and requires the returned "me" to match the site owner.
+func verifyIndieAuthToken(tokenEndpoint, meOwner, token string) error {
+	req, err := http.NewRequest("POST", tokenEndpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("micropub: token endpoint rejected token (status %d)", resp.StatusCode)
+	}
+
+	var verified struct {
+		Me string `json:"me"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&verified); err != nil {
+		return err
+	}
+
+	if strings.TrimRight(verified.Me, "/") != strings.TrimRight(meOwner, "/") {
+		return fmt.Errorf("micropub: token is for %q, not the site owner", verified.Me)
+	}
+	return nil
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.FormValue("access_token")
+}
+
+// handleMicropub serves the micropub endpoint: GET for config/source
+// queries, POST to create a new post or comment.
+func handleMicropub(tokenEndpoint, meOwner, siteURL string, posts *[]Post, postsMutex *sync.RWMutex, store *Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := bearerToken(r)
+		if token == "" || verifyIndieAuthToken(tokenEndpoint, meOwner, token) != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.Method == http.MethodGet {
+			handleMicropubGet(posts, postsMutex)(w, r)
+			return
+		}
+
+		entry, err := parseMicropubEntry(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		// "like-of"/"in-reply-to" requests (including Quill's contentless
+		// favorites) are comments on an existing post, not new posts
+		if entry.LikeOf != "" || entry.InReplyTo != "" || entry.Content == "" {
+			target := entry.InReplyTo
+			if target == "" {
+				target = entry.LikeOf
+			}
+			postId, err := postIdFromURL(target)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			content := entry.Content
+			if content == "" {
+				content = fmt.Sprintf("liked %s", target)
+			}
+			check(store.AddComment(postId, Comment{Name: meOwner, Content: content}, ""))
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		post, err := createPostFromMicropub(entry, posts, postsMutex)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// federate the new post to every follower's inbox
+		deliverPost(store, siteURL, &post)
+
+		w.Header().Set("Location", fmt.Sprintf("/posts/%s", post.Id))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func postIdFromURL(postURL string) (string, error) {
+	u, err := url.Parse(postURL)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] != "posts" {
+		return "", fmt.Errorf("micropub: %q is not a post URL", postURL)
+	}
+	return parts[1], nil
+}
+
+func parseMicropubEntry(r *http.Request) (micropubEntry, error) {
+	var entry micropubEntry
+
+	contentType := r.Header.Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/json") {
+		// micropub's JSON form wraps every property in a single-element array
+		var body struct {
+			Properties struct {
+				Content   []string `json:"content"`
+				Name      []string `json:"name"`
+				Category  []string `json:"category"`
+				Published []string `json:"published"`
+				LikeOf    []string `json:"like-of"`
+				InReplyTo []string `json:"in-reply-to"`
+			} `json:"properties"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return entry, err
+		}
+
+		entry.Content = first(body.Properties.Content)
+		entry.Name = first(body.Properties.Name)
+		entry.Published = first(body.Properties.Published)
+		entry.LikeOf = first(body.Properties.LikeOf)
+		entry.InReplyTo = first(body.Properties.InReplyTo)
+		entry.Category = body.Properties.Category
+		return entry, nil
+	}
+
+	if err := r.ParseForm(); err != nil {
+		return entry, err
+	}
+
+	entry.Content = r.FormValue("content")
+	entry.Name = r.FormValue("name")
+	entry.Published = r.FormValue("published")
+	entry.LikeOf = r.FormValue("like-of")
+	entry.InReplyTo = r.FormValue("in-reply-to")
+	entry.Category = r.Form["category[]"]
+
+	return entry, nil
+}
+
+// createPostFromMicropub writes a new markdown file with a frontmatter
+// header under posts/ and reloads the in-memory post list from disk.
+func createPostFromMicropub(entry micropubEntry, posts *[]Post, postsMutex *sync.RWMutex) (Post, error) {
+	// an h-entry with no "name" is an untitled note (Quill's normal case for
+	// a short post), not a post whose title is its entire body
+	title := entry.Name
+
+	date := entry.Published
+	if date == "" {
+		date = time.Now().Format(time.DateOnly)
+	}
+
+	var id string
+	if title != "" {
+		id = slugify(title)
+	} else {
+		id = fmt.Sprintf("note-%d", time.Now().Unix())
+	}
+
+	postsMutex.Lock()
+	defer postsMutex.Unlock()
+
+	// dedupe against an existing file (e.g. two title-less notes in the
+	// same second both generating "note-<timestamp>") rather than overwriting
+	// it; the frontmatter id below must use the deduped value too, since
+	// getPostList() trusts matter.Id over the filename
+	id = uniquePostId(id)
+	filename := fmt.Sprintf("%s.md", id)
+
+	var body strings.Builder
+	body.WriteString("---\n")
+	fmt.Fprintf(&body, "id: %s\n", id)
+	if title != "" {
+		fmt.Fprintf(&body, "title: %q\n", title)
+	}
+	fmt.Fprintf(&body, "date: %s\n", date)
+	if len(entry.Category) > 0 {
+		// %q each element so a category containing a comma, colon, or
+		// bracket can't produce malformed or silently wrong YAML
+		tags := make([]string, len(entry.Category))
+		for i, tag := range entry.Category {
+			tags[i] = fmt.Sprintf("%q", tag)
+		}
+		fmt.Fprintf(&body, "tags: [%s]\n", strings.Join(tags, ", "))
+	}
+	body.WriteString("---\n")
+	body.WriteString(entry.Content)
+
+	if err := os.WriteFile(fmt.Sprintf("posts/%s", filename), []byte(body.String()), 0644); err != nil {
+		return Post{}, err
+	}
+
+	*posts = getPostList()
+
+	newPost := Post{
+		Id:       id,
+		Date:     date,
+		Filename: filename,
+		Title:    title,
+		Tags:     entry.Category,
+	}
+	return newPost, nil
+}
+
+// uniquePostId appends a numeric suffix until it finds an id with no
+// existing posts/<id>.md file, so two posts that would otherwise slugify
+// to the same id don't silently overwrite one another.
+func uniquePostId(id string) string {
+	candidate := id
+	for i := 2; ; i++ {
+		if _, err := os.Stat(fmt.Sprintf("posts/%s.md", candidate)); os.IsNotExist(err) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d", id, i)
+	}
+}
+
+// handleMicropubGet answers ?q=config and ?q=source queries.
+func handleMicropubGet(posts *[]Post, postsMutex *sync.RWMutex) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("q") {
+		case "config":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			check(json.NewEncoder(w).Encode(struct {
+				MediaEndpoint       string   `json:"media-endpoint,omitempty"`
+				SyndicateTo         []string `json:"syndicate-to"`
+				PostTypes           []struct {
+					Type string `json:"type"`
+					Name string `json:"name"`
+				} `json:"post-types"`
+			}{
+				SyndicateTo: []string{},
+				PostTypes: []struct {
+					Type string `json:"type"`
+					Name string `json:"name"`
+				}{
+					{Type: "article", Name: "Article"},
+					{Type: "note", Name: "Note"},
+				},
+			}))
+
+		case "source":
+			postId, err := postIdFromURL(r.URL.Query().Get("url"))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			var post *Post
+			postsMutex.RLock()
+			for i := range *posts {
+				if (*posts)[i].Id == postId {
+					found := (*posts)[i]
+					post = &found
+					break
+				}
+			}
+			postsMutex.RUnlock()
+			if post == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			check(json.NewEncoder(w).Encode(struct {
+				Type       []string `json:"type"`
+				Properties struct {
+					Name      []string `json:"name"`
+					Published []string `json:"published"`
+				} `json:"properties"`
+			}{
+				Type: []string{"h-entry"},
+				Properties: struct {
+					Name      []string `json:"name"`
+					Published []string `json:"published"`
+				}{
+					Name:      []string{post.Title},
+					Published: []string{post.Date},
+				},
+			}))
+
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}
+}
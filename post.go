@@ -1,22 +1,49 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"html/template"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 
+	"github.com/adrg/frontmatter"
 	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/html"
 	"github.com/gomarkdown/markdown/parser"
 )
 
+// renderCache avoids re-parsing markdown on every feed/page hit. Entries are
+// keyed by filename and invalidated by comparing the stored mtime, so an
+// edited post re-renders on its next request.
+var renderCache = struct {
+	sync.Mutex
+	entries map[string]renderCacheEntry
+}{entries: make(map[string]renderCacheEntry)}
+
+type renderCacheEntry struct {
+	mtime time.Time
+	html  template.HTML
+}
+
+// clearRenderCache drops every cached render; used by dev mode when a
+// watched file changes, so the next request re-parses from disk.
+func clearRenderCache() {
+	renderCache.Lock()
+	renderCache.entries = make(map[string]renderCacheEntry)
+	renderCache.Unlock()
+}
+
 type Post struct {
 	Id string
 	Date string
 	Filename string
 	Title string
+	Tags []string
+	Draft bool
+	Summary string
 }
 
 type Comment struct {
@@ -24,6 +51,17 @@ type Comment struct {
 	Content string
 }
 
+// postFrontmatter mirrors the YAML block at the top of each posts/*.md
+// file; adrg/frontmatter fills it in and hands back the markdown body.
+type postFrontmatter struct {
+	Id      string   `yaml:"id"`
+	Title   string   `yaml:"title"`
+	Date    string   `yaml:"date"`
+	Tags    []string `yaml:"tags"`
+	Draft   bool     `yaml:"draft"`
+	Summary string   `yaml:"summary"`
+}
+
 func (post *Post) HumanDate() (string) {
 	parsedDate, err := time.Parse(time.DateOnly, post.Date)
 	check(err)
@@ -31,21 +69,98 @@ func (post *Post) HumanDate() (string) {
 	return parsedDate.Format("Monday, January _2")
 }
 
+// previewToken is the value of ?preview= that unlocks drafts; set via the
+// PREVIEW_TOKEN env var so drafts stay hidden by default.
+var previewToken = os.Getenv("PREVIEW_TOKEN")
+
+// getPostList walks posts/*.md, parsing each file's frontmatter block into
+// a Post. This replaces the old posts/posts.json manifest, so markdown and
+// metadata can never drift out of sync.
 func getPostList() ([]Post) {
-	postListJson, err := os.ReadFile("posts/posts.json")
+	files, err := filepath.Glob("posts/*.md")
 	check(err)
 
-	// parse JSON
 	var posts []Post
-	check(json.Unmarshal(postListJson, &posts))
+	for _, path := range files {
+		file, err := os.Open(path)
+		check(err)
+
+		var matter postFrontmatter
+		_, err = frontmatter.Parse(file, &matter)
+		file.Close()
+		check(err)
+
+		id := matter.Id
+		if id == "" {
+			id = slugify(matter.Title)
+		}
+
+		posts = append(posts, Post{
+			Id:       id,
+			Date:     matter.Date,
+			Filename: filepath.Base(path),
+			Title:    matter.Title,
+			Tags:     matter.Tags,
+			Draft:    matter.Draft,
+			Summary:  matter.Summary,
+		})
+	}
+
+	// filepath.Glob returns filenames in lexicographic order, not
+	// chronological; sort newest-first like the old posts.json manifest did
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Date > posts[j].Date
+	})
 
 	return posts
 }
 
+// visiblePosts drops drafts unless the supplied preview token matches
+// PREVIEW_TOKEN, so editors can share a draft link without publishing it.
+func visiblePosts(posts []Post, preview string) []Post {
+	visible := make([]Post, 0, len(posts))
+	for _, post := range posts {
+		if post.Draft && (previewToken == "" || preview != previewToken) {
+			continue
+		}
+		visible = append(visible, post)
+	}
+	return visible
+}
+
+// HasTag reports whether the post is tagged with the given tag, used by
+// the /tags/{tag} handler.
+func (post *Post) HasTag(tag string) bool {
+	for _, t := range post.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // used by the template
 func (post *Post) Render() (template.HTML) {
-	// read file
-	postContents, err := os.ReadFile(fmt.Sprintf("posts/%s", post.Filename))
+	path := fmt.Sprintf("posts/%s", post.Filename)
+
+	info, err := os.Stat(path)
+	check(err)
+	mtime := info.ModTime()
+
+	renderCache.Lock()
+	if entry, ok := renderCache.entries[post.Filename]; ok && entry.mtime.Equal(mtime) {
+		renderCache.Unlock()
+		return entry.html
+	}
+	renderCache.Unlock()
+
+	// read file, stripping the frontmatter block so only the markdown body
+	// is rendered
+	file, err := os.Open(path)
+	check(err)
+	var matter postFrontmatter
+	postContents, err := frontmatter.Parse(file, &matter)
+	file.Close()
 	check(err)
 
 	extensions := parser.CommonExtensions | parser.AutoHeadingIDs | parser.NoEmptyLineBeforeBlock | parser.Footnotes
@@ -57,5 +172,11 @@ func (post *Post) Render() (template.HTML) {
 	opts := html.RendererOptions{Flags: htmlFlags}
 	renderer := html.NewRenderer(opts)
 
-	return template.HTML(string(markdown.Render(doc, renderer)))
+	rendered := template.HTML(string(markdown.Render(doc, renderer)))
+
+	renderCache.Lock()
+	renderCache.entries[post.Filename] = renderCacheEntry{mtime: mtime, html: rendered}
+	renderCache.Unlock()
+
+	return rendered
 }
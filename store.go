@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Follower is a remote actor who has followed the blog over ActivityPub.
+type Follower struct {
+	ActorIRI string
+	Inbox    string
+}
+
+// Store persists federation state (followers, the blog's RSA keypair, and
+// comments) so it survives restarts. comments used to live in an in-memory
+// map; they now live here alongside everything else ActivityPub needs.
+type Store struct {
+	db *sql.DB
+}
+
+// NewStore opens (creating if necessary) the sqlite database at path and
+// runs the schema migrations needed for federation state.
+func NewStore(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS followers (
+			actor_iri TEXT PRIMARY KEY,
+			inbox TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS keys (
+			id INTEGER PRIMARY KEY CHECK (id = 1),
+			private_key_pem TEXT NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS comments (
+			post_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			content TEXT NOT NULL,
+			source_iri TEXT
+		);
+	`); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// AddFollower records (or updates the inbox of) a remote follower.
+func (s *Store) AddFollower(actorIRI, inbox string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO followers (actor_iri, inbox) VALUES (?, ?)
+		 ON CONFLICT(actor_iri) DO UPDATE SET inbox = excluded.inbox`,
+		actorIRI, inbox,
+	)
+	return err
+}
+
+// RemoveFollower drops a follower, e.g. in response to an Undo Follow.
+func (s *Store) RemoveFollower(actorIRI string) error {
+	_, err := s.db.Exec(`DELETE FROM followers WHERE actor_iri = ?`, actorIRI)
+	return err
+}
+
+// Followers returns everyone currently following the blog.
+func (s *Store) Followers() ([]Follower, error) {
+	rows, err := s.db.Query(`SELECT actor_iri, inbox FROM followers`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var followers []Follower
+	for rows.Next() {
+		var f Follower
+		if err := rows.Scan(&f.ActorIRI, &f.Inbox); err != nil {
+			return nil, err
+		}
+		followers = append(followers, f)
+	}
+	return followers, rows.Err()
+}
+
+// PrivateKey returns the blog's RSA private key, generating and persisting
+// one on first use so the actor's key stays stable across restarts.
+func (s *Store) PrivateKey() (*rsa.PrivateKey, error) {
+	var keyPem string
+	err := s.db.QueryRow(`SELECT private_key_pem FROM keys WHERE id = 1`).Scan(&keyPem)
+
+	if err == sql.ErrNoRows {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+
+		block := &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+		keyPem = string(pem.EncodeToMemory(block))
+
+		if _, err := s.db.Exec(`INSERT INTO keys (id, private_key_pem) VALUES (1, ?)`, keyPem); err != nil {
+			return nil, err
+		}
+		return key, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode([]byte(keyPem))
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// AddComment stores a comment, whether submitted via the web form (sourceIRI
+// empty) or federated in as a reply (sourceIRI set to the remote Note's id).
+func (s *Store) AddComment(postId string, c Comment, sourceIRI string) error {
+	_, err := s.db.Exec(
+		`INSERT INTO comments (post_id, name, content, source_iri) VALUES (?, ?, ?, ?)`,
+		postId, c.Name, c.Content, sourceIRI,
+	)
+	return err
+}
+
+// Comments returns every comment (web or federated) left on a post.
+func (s *Store) Comments(postId string) ([]Comment, error) {
+	rows, err := s.db.Query(`SELECT name, content FROM comments WHERE post_id = ?`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []Comment
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.Name, &c.Content); err != nil {
+			return nil, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, rows.Err()
+}
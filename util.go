@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"html/template"
+	"io"
 	"net/http"
+
+	"github.com/grimsteel/go-blog/contenttype"
 )
 
 // jetbrains
@@ -23,7 +26,9 @@ func renderTemplate(data any, templateFile string, w http.ResponseWriter) {
 	)
 
 	check(err)
-	
-	// multiple separate templates 
-	check(t.ExecuteTemplate(w, "base", data))
+
+	// multiple separate templates
+	check(contenttype.WriteHTML(w, func(out io.Writer) error {
+		return t.ExecuteTemplate(out, "base", data)
+	}))
 }